@@ -19,6 +19,9 @@ package parse
 type Node interface {
 	Type() NodeType
 	LineNumber() Line
+	StartOffset() int
+	EndOffset() int
+	Column() int
 	String() string
 }
 
@@ -33,6 +36,28 @@ func (line Line) LineNumber() Line {
 	return line
 }
 
+// Position records the byte range a Node spans in its source file, along
+// with the column of its first byte. It is embedded (alongside NodeType and
+// Line) by every concrete Node so offsets survive from the lexer's items
+// all the way into the AST.
+type Position struct {
+	startOffset int
+	endOffset   int
+	column      int
+}
+
+func (p Position) StartOffset() int {
+	return p.startOffset
+}
+
+func (p Position) EndOffset() int {
+	return p.endOffset
+}
+
+func (p Position) Column() int {
+	return p.column
+}
+
 func (t NodeType) String() string {
 	switch t {
 	case NodeRoot:
@@ -55,6 +80,8 @@ func (t NodeType) String() string {
 		return "Method"
 	case NodeField:
 		return "Field"
+	case NodePreprocessor:
+		return "Preprocessor"
 	default:
 		return "Invalid"
 	}
@@ -71,17 +98,21 @@ const (
 	NodeGroupingDelimiter
 	NodeMethod
 	NodeField
+	NodePreprocessor
 )
 
 type RootNode struct {
 	NodeType
 	Line
-	Child *NamespaceNode
+	Position
+	Child        *NamespaceNode
+	Preprocessor []PreprocessorNode
 }
 
 type NamespaceNode struct {
 	NodeType
 	Line
+	Position
 	Name     string
 	Children []Node
 }
@@ -89,28 +120,54 @@ type NamespaceNode struct {
 type ClassNode struct {
 	NodeType
 	Line
-	Name    string
-	Members []Node
+	Position
+	DocComment         string
+	Name               string
+	Members            []Node
+	TemplateParameters string
+	TemplateParams     []TemplateParam
 }
 
 type StructNode struct {
 	NodeType
 	Line
-	Name         string
-	Members      []Node
-	InstanceName string
+	Position
+	DocComment         string
+	Name               string
+	Members            []Node
+	InstanceName       string
+	TemplateParameters string
+	TemplateParams     []TemplateParam
+}
+
+// TemplateParam is a single parsed entry of a `template<...>` parameter
+// list, e.g. `typename T = void` -> {Name: "T", Kind: "typename", Default: "void"}.
+type TemplateParam struct {
+	Name    string
+	Kind    string
+	Default string
 }
 
 type EnumNode struct {
 	NodeType
 	Line
-	Name   string
-	Values []string
+	Position
+	DocComment string
+	Name       string
+	Values     []EnumValue
+}
+
+// EnumValue is a single enumerator, along with the `// ...` comment (if any)
+// trailing it on the same line.
+type EnumValue struct {
+	Name            string
+	TrailingComment string
 }
 
 type UsingNode struct {
 	NodeType
 	Line
+	Position
 	Name string
 	Rhs  string
 }
@@ -118,12 +175,14 @@ type UsingNode struct {
 type AccessSpecifierNode struct {
 	NodeType
 	Line
+	Position
 	Access string
 }
 
 type GroupingDelimiterNode struct {
 	NodeType
 	Line
+	Position
 	DocString        string
 	OpeningDelimiter bool
 	ClosingDelimiter bool
@@ -132,17 +191,24 @@ type GroupingDelimiterNode struct {
 type MethodNode struct {
 	NodeType
 	Line
-	Name       string
-	ReturnType string
-	Arguments  string
-	Body       string
-	IsTemplate bool
+	Position
+	DocComment         string
+	Name               string
+	ReturnType         string
+	Arguments          string
+	Body               string
+	IsTemplate         bool
+	TemplateParameters string
+	TemplateParams     []TemplateParam
 }
 
 type FieldNode struct {
 	NodeType
 	Line
-	Name      string
-	FieldType string
-	Rhs       string
+	Position
+	DocComment      string
+	Name            string
+	FieldType       string
+	Rhs             string
+	TrailingComment string
 }
@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2022 The Android Open Source Project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+// Severity classifies how serious a Diagnostic is.
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "unknown"
+	}
+}
+
+// Diagnostic is a single problem found while lexing or parsing a header.
+type Diagnostic struct {
+	Pos      int
+	Line     int
+	Col      int
+	Msg      string
+	Severity Severity
+}
+
+// Handler is called with each Diagnostic as it is discovered.
+type Handler func(Diagnostic)
+
+// withHandler installs the Handler that errorf reports Diagnostics to. It is
+// unexported: diagnostic collection is wired up by Parse, not configured
+// directly by lexer clients.
+func withHandler(h Handler) Option {
+	return func(l *lexer) {
+		l.handler = h
+	}
+}
@@ -0,0 +1,287 @@
+/*
+ * Copyright (C) 2022 The Android Open Source Project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonNode is the stable, self-describing wire schema every concrete Node
+// is encoded to and decoded from. NodeKind disambiguates which of the
+// optional fields below apply; fields that don't apply to a given kind are
+// simply omitted.
+type jsonNode struct {
+	NodeKind    string `json:"node"`
+	Line        Line   `json:"line"`
+	StartOffset int    `json:"startOffset"`
+	EndOffset   int    `json:"endOffset"`
+	Column      int    `json:"column"`
+
+	Name       string `json:"name,omitempty"`
+	DocComment string `json:"docComment,omitempty"`
+
+	Child        *jsonNode  `json:"child,omitempty"`
+	Children     []jsonNode `json:"children,omitempty"`
+	Members      []jsonNode `json:"members,omitempty"`
+	Preprocessor []jsonNode `json:"preprocessor,omitempty"`
+
+	InstanceName       string          `json:"instanceName,omitempty"`
+	Values             []EnumValue     `json:"values,omitempty"`
+	Rhs                string          `json:"rhs,omitempty"`
+	Access             string          `json:"access,omitempty"`
+	DocString          string          `json:"docString,omitempty"`
+	OpeningDelimiter   bool            `json:"openingDelimiter,omitempty"`
+	ClosingDelimiter   bool            `json:"closingDelimiter,omitempty"`
+	ReturnType         string          `json:"returnType,omitempty"`
+	Arguments          string          `json:"arguments,omitempty"`
+	Body               string          `json:"body,omitempty"`
+	IsTemplate         bool            `json:"isTemplate,omitempty"`
+	TemplateParameters string          `json:"templateParameters,omitempty"`
+	TemplateParams     []TemplateParam `json:"templateParams,omitempty"`
+	FieldType          string          `json:"fieldType,omitempty"`
+	TrailingComment    string          `json:"trailingComment,omitempty"`
+	Directive          string          `json:"directive,omitempty"`
+	Args               string          `json:"args,omitempty"`
+}
+
+// EncodeJSON serializes root's full tree, including position and
+// doc-comment fields, to the stable schema described by jsonNode.
+func EncodeJSON(root Node, w io.Writer) error {
+	jn, err := toJSONNode(root)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(jn)
+}
+
+// DecodeJSON reads a tree previously written by EncodeJSON and reconstructs
+// its Nodes.
+func DecodeJSON(r io.Reader) (Node, error) {
+	var jn jsonNode
+	if err := json.NewDecoder(r).Decode(&jn); err != nil {
+		return nil, err
+	}
+	return fromJSONNode(jn)
+}
+
+func toJSONNode(n Node) (jsonNode, error) {
+	jn := jsonNode{
+		NodeKind:    n.Type().String(),
+		Line:        n.LineNumber(),
+		StartOffset: n.StartOffset(),
+		EndOffset:   n.EndOffset(),
+		Column:      n.Column(),
+	}
+	switch t := n.(type) {
+	case *RootNode:
+		if t.Child != nil {
+			child, err := toJSONNode(t.Child)
+			if err != nil {
+				return jn, err
+			}
+			jn.Child = &child
+		}
+		for i := range t.Preprocessor {
+			pn, err := toJSONNode(&t.Preprocessor[i])
+			if err != nil {
+				return jn, err
+			}
+			jn.Preprocessor = append(jn.Preprocessor, pn)
+		}
+	case *NamespaceNode:
+		jn.Name = t.Name
+		children, err := toJSONNodes(t.Children)
+		if err != nil {
+			return jn, err
+		}
+		jn.Children = children
+	case *ClassNode:
+		jn.Name = t.Name
+		jn.DocComment = t.DocComment
+		jn.TemplateParameters = t.TemplateParameters
+		jn.TemplateParams = t.TemplateParams
+		members, err := toJSONNodes(t.Members)
+		if err != nil {
+			return jn, err
+		}
+		jn.Members = members
+	case *StructNode:
+		jn.Name = t.Name
+		jn.DocComment = t.DocComment
+		jn.InstanceName = t.InstanceName
+		jn.TemplateParameters = t.TemplateParameters
+		jn.TemplateParams = t.TemplateParams
+		members, err := toJSONNodes(t.Members)
+		if err != nil {
+			return jn, err
+		}
+		jn.Members = members
+	case *EnumNode:
+		jn.Name = t.Name
+		jn.DocComment = t.DocComment
+		jn.Values = t.Values
+	case *UsingNode:
+		jn.Name = t.Name
+		jn.Rhs = t.Rhs
+	case *AccessSpecifierNode:
+		jn.Access = t.Access
+	case *GroupingDelimiterNode:
+		jn.DocString = t.DocString
+		jn.OpeningDelimiter = t.OpeningDelimiter
+		jn.ClosingDelimiter = t.ClosingDelimiter
+	case *MethodNode:
+		jn.Name = t.Name
+		jn.DocComment = t.DocComment
+		jn.ReturnType = t.ReturnType
+		jn.Arguments = t.Arguments
+		jn.Body = t.Body
+		jn.IsTemplate = t.IsTemplate
+		jn.TemplateParameters = t.TemplateParameters
+		jn.TemplateParams = t.TemplateParams
+	case *FieldNode:
+		jn.Name = t.Name
+		jn.DocComment = t.DocComment
+		jn.FieldType = t.FieldType
+		jn.Rhs = t.Rhs
+		jn.TrailingComment = t.TrailingComment
+	case *PreprocessorNode:
+		jn.Directive = t.Directive
+		jn.Args = t.Args
+	default:
+		return jn, fmt.Errorf("parse: EncodeJSON: unsupported node type %T", n)
+	}
+	return jn, nil
+}
+
+func toJSONNodes(nodes []Node) ([]jsonNode, error) {
+	if nodes == nil {
+		return nil, nil
+	}
+	out := make([]jsonNode, len(nodes))
+	for i, n := range nodes {
+		jn, err := toJSONNode(n)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = jn
+	}
+	return out, nil
+}
+
+func fromJSONNode(jn jsonNode) (Node, error) {
+	pos := Position{startOffset: jn.StartOffset, endOffset: jn.EndOffset, column: jn.Column}
+	switch jn.NodeKind {
+	case NodeRoot.String():
+		n := &RootNode{NodeType: NodeRoot, Line: jn.Line, Position: pos}
+		if jn.Child != nil {
+			child, err := fromJSONNode(*jn.Child)
+			if err != nil {
+				return nil, err
+			}
+			namespace, ok := child.(*NamespaceNode)
+			if !ok {
+				return nil, fmt.Errorf("parse: DecodeJSON: RootNode.child must be a Namespace, got %T", child)
+			}
+			n.Child = namespace
+		}
+		for _, pjn := range jn.Preprocessor {
+			pn, err := fromJSONNode(pjn)
+			if err != nil {
+				return nil, err
+			}
+			preproc, ok := pn.(*PreprocessorNode)
+			if !ok {
+				return nil, fmt.Errorf("parse: DecodeJSON: RootNode.preprocessor entries must be Preprocessor, got %T", pn)
+			}
+			n.Preprocessor = append(n.Preprocessor, *preproc)
+		}
+		return n, nil
+	case NodeNamespace.String():
+		children, err := fromJSONNodes(jn.Children)
+		if err != nil {
+			return nil, err
+		}
+		return &NamespaceNode{NodeType: NodeNamespace, Line: jn.Line, Position: pos, Name: jn.Name, Children: children}, nil
+	case NodeClass.String():
+		members, err := fromJSONNodes(jn.Members)
+		if err != nil {
+			return nil, err
+		}
+		return &ClassNode{
+			NodeType: NodeClass, Line: jn.Line, Position: pos,
+			DocComment: jn.DocComment, Name: jn.Name, Members: members,
+			TemplateParameters: jn.TemplateParameters, TemplateParams: jn.TemplateParams,
+		}, nil
+	case NodeStruct.String():
+		members, err := fromJSONNodes(jn.Members)
+		if err != nil {
+			return nil, err
+		}
+		return &StructNode{
+			NodeType: NodeStruct, Line: jn.Line, Position: pos,
+			DocComment: jn.DocComment, Name: jn.Name, Members: members, InstanceName: jn.InstanceName,
+			TemplateParameters: jn.TemplateParameters, TemplateParams: jn.TemplateParams,
+		}, nil
+	case NodeEnum.String():
+		return &EnumNode{NodeType: NodeEnum, Line: jn.Line, Position: pos, DocComment: jn.DocComment, Name: jn.Name, Values: jn.Values}, nil
+	case NodeUsing.String():
+		return &UsingNode{NodeType: NodeUsing, Line: jn.Line, Position: pos, Name: jn.Name, Rhs: jn.Rhs}, nil
+	case NodeAccessSpecifier.String():
+		return &AccessSpecifierNode{NodeType: NodeAccessSpecifier, Line: jn.Line, Position: pos, Access: jn.Access}, nil
+	case NodeGroupingDelimiter.String():
+		return &GroupingDelimiterNode{
+			NodeType: NodeGroupingDelimiter, Line: jn.Line, Position: pos,
+			DocString: jn.DocString, OpeningDelimiter: jn.OpeningDelimiter, ClosingDelimiter: jn.ClosingDelimiter,
+		}, nil
+	case NodeMethod.String():
+		return &MethodNode{
+			NodeType: NodeMethod, Line: jn.Line, Position: pos,
+			DocComment: jn.DocComment, Name: jn.Name, ReturnType: jn.ReturnType, Arguments: jn.Arguments,
+			Body: jn.Body, IsTemplate: jn.IsTemplate,
+			TemplateParameters: jn.TemplateParameters, TemplateParams: jn.TemplateParams,
+		}, nil
+	case NodeField.String():
+		return &FieldNode{
+			NodeType: NodeField, Line: jn.Line, Position: pos,
+			DocComment: jn.DocComment, Name: jn.Name, FieldType: jn.FieldType, Rhs: jn.Rhs,
+			TrailingComment: jn.TrailingComment,
+		}, nil
+	case NodePreprocessor.String():
+		return &PreprocessorNode{NodeType: NodePreprocessor, Line: jn.Line, Position: pos, Directive: jn.Directive, Args: jn.Args}, nil
+	default:
+		return nil, fmt.Errorf("parse: DecodeJSON: unknown node kind %q", jn.NodeKind)
+	}
+}
+
+func fromJSONNodes(nodes []jsonNode) ([]Node, error) {
+	if nodes == nil {
+		return nil, nil
+	}
+	out := make([]Node, len(nodes))
+	for i, jn := range nodes {
+		n, err := fromJSONNode(jn)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = n
+	}
+	return out, nil
+}
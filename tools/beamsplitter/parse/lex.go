@@ -25,17 +25,19 @@ import (
 
 // item represents a token or text string returned from the scanner.
 type item struct {
-	typ  itemType // The type of this item.
-	pos  int      // The starting position, in bytes, of this item in the input string.
-	val  string   // The value of this item.
-	line int      // The line number at the start of this item.
+	typ    itemType // The type of this item.
+	pos    int      // The starting position, in bytes, of this item in the input string.
+	endPos int      // The position, in bytes, just past the end of this item in the input string.
+	val    string   // The value of this item.
+	line   int      // The line number at the start of this item.
+	col    int      // The column, in bytes since the last newline, at the start of this item.
 }
 
 func (i item) String() string {
 	switch {
 	case i.typ == itemEOF:
 		return "EOF"
-	case i.typ == itemError:
+	case i.typ == itemError || i.typ == itemRecovered:
 		return i.val
 	case i.typ > itemKeyword:
 		return fmt.Sprintf("<%s>", i.val)
@@ -52,6 +54,8 @@ const (
 	itemError                  itemType = iota // error occurred; value is text of error
 	itemBlockCommentGroupBegin                 // starts with `/**`, ends with `*/`, contains `@{`
 	itemBlockCommentGroupEnd                   // starts with `/**`, ends with `*/`, contains `@}`
+	itemDocComment                             // a `/** ... */` or a run of `///` lines documenting the next declaration
+	itemTrailingComment                        // a `// ...` comment on the same line as a field or enum value
 	itemSimpleType                             // examples: `Texture* const`, `uint8_t`, `BlendMode`
 	itemMethodBody                             // blob with the entire contents of an inlined method
 	itemMethodArgs                             // unparsed blob, includes outermost with `()`
@@ -59,6 +63,7 @@ const (
 	itemDefaultValue                           // an unparsed RHS expression
 	itemIdentifier                             // legal C++ identifier
 	itemEOF
+	itemRecovered // a Handler absorbed a recoverable error and lexing resynced; value is text of the error
 
 	itemSymbol // unused enum separator
 	itemOpenBrace
@@ -93,12 +98,47 @@ type lexer struct {
 	items             chan item // channel of scanned items
 	line              int       // 1+number of newlines seen
 	startLine         int       // start line of this item
+	lineStart         int       // byte offset of the start of the current line
+	startCol          int       // start column (in bytes since lineStart) of this item
 	parenDepth        int       // nesting depth of () expressions
 	braceDepth        int       // nesting depth of {} expressions
 	angleBracketDepth int       // nesting depth of <> expressions
 	pos               int       // current position in the input
 	start             int       // start position of this item
 	atEOF             bool      // we have hit the end of input
+	emitComments      bool      // whether doc and trailing comments are emitted as items
+	commentIsDoc      bool      // whether the comment currently being scanned is a doc comment
+	commentResume     stateFn   // where to resume once the comment being scanned is consumed
+	handler           Handler   // receives Diagnostics; nil falls back to the legacy itemError item
+
+	// blockStack records, for each `{...}` scope currently open, the loop
+	// function to resume once its matching `}` is reached: lexRootFn for a
+	// top-level namespace, lexBlockFn for one nested inside another
+	// namespace or a class/struct, and so on. currentLoop mirrors whichever
+	// of lexRootFn/lexBlockFn/lexMembersFn/lexEnum is presently active, so
+	// syncToRecovery can resync to the right grammar instead of always
+	// restarting at the root.
+	blockStack  []stateFn
+	currentLoop stateFn
+
+	// The following are only consulted by Parse, to configure the
+	// preprocess pass that runs ahead of lexing; the lexer itself never
+	// reads them.
+	defines              map[string]string
+	includeResolver      IncludeResolver
+	preservePreprocessor bool
+}
+
+// Option configures a lexer (and, transitively, the parser built on top of it).
+type Option func(*lexer)
+
+// EmitComments controls whether `/** ... */` and `///` doc comments, along
+// with trailing `// ...` comments, are emitted as itemDocComment and
+// itemTrailingComment items. It defaults to true.
+func EmitComments(emit bool) Option {
+	return func(l *lexer) {
+		l.emitComments = emit
+	}
 }
 
 // next returns the next rune in the input.
@@ -111,6 +151,7 @@ func (l *lexer) next() rune {
 	l.pos += w
 	if r == '\n' {
 		l.line++
+		l.lineStart = l.pos
 	}
 	return r
 }
@@ -127,9 +168,10 @@ func (l *lexer) backup() {
 	if !l.atEOF && l.pos > 0 {
 		r, w := utf8.DecodeLastRuneInString(l.input[:l.pos])
 		l.pos -= w
-		// Correct newline count.
+		// Correct newline count and the start of the line we backed into.
 		if r == '\n' {
 			l.line--
+			l.lineStart = strings.LastIndex(l.input[:l.pos], "\n") + 1
 		}
 	}
 }
@@ -142,16 +184,19 @@ func (lex *lexer) backupMultiple(count int) {
 
 // emit passes an item back to the client.
 func (l *lexer) emit(t itemType) {
-	l.items <- item{t, l.start, l.input[l.start:l.pos], l.startLine}
+	l.items <- item{t, l.start, l.pos, l.input[l.start:l.pos], l.startLine, l.startCol}
 	l.start = l.pos
 	l.startLine = l.line
+	l.startCol = l.start - l.lineStart
 }
 
-// ignore skips over the pending input before this point.
+// ignore skips over the pending input before this point. l.line itself is
+// already current: next() increments it on every '\n' it consumes, so
+// there's nothing left to count here.
 func (l *lexer) ignore() {
-	l.line += strings.Count(l.input[l.start:l.pos], "\n")
 	l.start = l.pos
 	l.startLine = l.line
+	l.startCol = l.start - l.lineStart
 }
 
 // accept consumes the next rune if it's from the valid set.
@@ -194,7 +239,9 @@ func (l *lexer) acceptRune(expected rune) bool {
 func (lex *lexer) acceptString(expectedString string) bool {
 	for i, c := range expectedString {
 		if lex.next() != c {
-			lex.backupMultiple(i)
+			// next() above already consumed one more rune than the i
+			// matched so far, so back up i+1, not i.
+			lex.backupMultiple(i + 1)
 			return false
 		}
 	}
@@ -228,11 +275,85 @@ func (lex *lexer) acceptKeyword(keyword string) bool {
 	return true
 }
 
-// errorf returns an error token and terminates the scan by passing
-// back a nil pointer that will be the next state, terminating l.nextItem.
+// errorf reports a Diagnostic for a recoverable problem (such as "Badly
+// formed struct") and resyncs to the next `;` or matching `}` so lexing can
+// continue rather than aborting the whole file. If no Handler is installed
+// it falls back to the legacy behavior of emitting an itemError and
+// terminating the scan by passing back a nil pointer that will be the next
+// state, terminating l.nextItem.
+//
+// When a Handler is installed, an itemRecovered item is sent first rather
+// than itemError: the parser is partway through building whatever node is
+// at the point of error (it may already have consumed some of that node's
+// tokens), and without a concrete signal in the stream it would otherwise
+// keep reading past the error as if nothing had happened, attributing
+// tokens from whatever well-formed declaration comes after the resync
+// point to the broken one. itemRecovered is deliberately distinct from
+// itemError: more items always follow it (lexing continues after the
+// resync), whereas itemError conventionally means the channel is about to
+// close, so parser loops that read "one more declaration" treat
+// itemRecovered as "abandon what I was building" without mistaking it for
+// the end of the enclosing scope.
 func (l *lexer) errorf(format string, args ...any) stateFn {
-	l.items <- item{itemError, l.start, fmt.Sprintf(format, args...), l.startLine}
-	return nil
+	msg := fmt.Sprintf(format, args...)
+	if l.handler == nil {
+		l.items <- item{itemError, l.start, l.pos, msg, l.startLine, l.startCol}
+		return nil
+	}
+	l.items <- item{itemRecovered, l.start, l.pos, msg, l.startLine, l.startCol}
+	l.handler(Diagnostic{l.start, l.startLine, l.startCol, msg, SeverityError})
+	return syncToRecovery(l)
+}
+
+// syncToRecovery skips input until the next `;` or `}` at or above the
+// brace depth the error occurred at, then resumes lexing in the grammar
+// that was active when the error occurred: a `;` resumes l.currentLoop
+// itself (no scope was closed), a `}` resumes whatever l.blockStack says
+// comes after the scope that `}` just closed, and running off the end of
+// the file emits itemEOF so Parse's loop (which always installs a
+// Handler, and so always routes errors through here) terminates instead of
+// spinning on a closed, drained channel.
+func syncToRecovery(l *lexer) stateFn {
+	depth := l.braceDepth
+	for {
+		switch l.next() {
+		case eof:
+			l.ignore()
+			l.emit(itemEOF)
+			return nil
+		case '{':
+			l.braceDepth++
+		case '}':
+			if l.braceDepth <= depth {
+				l.ignore()
+				return l.popBlock()
+			}
+			l.braceDepth--
+		case ';':
+			if l.braceDepth <= depth {
+				l.ignore()
+				return l.currentLoop
+			}
+		}
+	}
+}
+
+// pushBlock records resume as the loop to continue in once the `{...}`
+// scope about to be entered reaches its matching `}`.
+func (l *lexer) pushBlock(resume stateFn) {
+	l.blockStack = append(l.blockStack, resume)
+}
+
+// popBlock returns (and forgets) the loop most recently pushed by
+// pushBlock. An empty stack means a stray `}` closed more scopes than were
+// ever opened; resuming at lexRootFn is the safest fallback.
+func (l *lexer) popBlock() stateFn {
+	if len(l.blockStack) == 0 {
+		return lexRootFn
+	}
+	resume := l.blockStack[len(l.blockStack)-1]
+	l.blockStack = l.blockStack[:len(l.blockStack)-1]
+	return resume
 }
 
 // nextItem returns the next item from the input.
@@ -253,13 +374,18 @@ func (lex *lexer) eof() bool {
 }
 
 // lex creates a new scanner for the input string.
-func lex(name, input string) *lexer {
+func lex(name, input string, opts ...Option) *lexer {
 	l := &lexer{
-		name:      name,
-		input:     input,
-		items:     make(chan item),
-		line:      1,
-		startLine: 1,
+		name:         name,
+		input:        input,
+		items:        make(chan item),
+		line:         1,
+		startLine:    1,
+		emitComments: true,
+		currentLoop:  lexRootFn,
+	}
+	for _, opt := range opts {
+		opt(l)
 	}
 	go l.run()
 	return l
@@ -277,27 +403,38 @@ func isAlphaNumeric(r rune) bool {
 	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
 }
 
+// skipSpace consumes and ignores any run of whitespace at the current
+// position. Keywords and symbols are lexed with no embedded whitespace of
+// their own, so state functions call this between one token and the next;
+// those that must stop at a newline (to find a trailing comment on the same
+// line) skip only spaces and tabs with acceptRun instead.
+func skipSpace(lex *lexer) {
+	lex.acceptRun(" \n\t")
+	lex.ignore()
+}
+
 // state functions
 
 func lexRootFn(lex *lexer) stateFn {
+	lex.currentLoop = lexRootFn
 	if lex.eof() {
+		lex.emit(itemEOF)
 		return nil
 	}
 	if lex.acceptSpace() {
 		lex.acceptRun(" \n\t")
+		lex.ignore()
 		return lexRootFn
 	}
-	if lex.acceptString("/*") {
-		return lexBlockCommentFn(lex)
-	}
-	if lex.acceptString("//") {
-		return lexLineCommentFn(lex)
+	if next, ok := lexComment(lex, lexRootFn); ok {
+		return next
 	}
 	if lex.acceptRune('#') {
 		return lexEatLineFn(lex)
 	}
 	if lex.acceptKeyword("namespace") {
 		lex.emit(itemNamespace)
+		lex.pushBlock(lexRootFn)
 		return lexNamespaceFn(lex)
 	}
 	return lex.errorf("Expected namespace")
@@ -305,12 +442,14 @@ func lexRootFn(lex *lexer) stateFn {
 
 // Upon entry we are just past the namespace keyword.
 func lexNamespaceFn(lex *lexer) stateFn {
+	skipSpace(lex)
 	if lex.acceptRune('{') {
 		lex.emit(itemOpenBrace)
 		return lexBlockFn(lex)
 	}
 	if lex.acceptIdentifier() {
 		lex.emit(itemIdentifier)
+		skipSpace(lex)
 		if lex.acceptRune('{') {
 			lex.emit(itemOpenBrace)
 			return lexBlockFn(lex)
@@ -319,79 +458,553 @@ func lexNamespaceFn(lex *lexer) stateFn {
 	return lex.errorf("Badly formed namespace")
 }
 
+// lexBlockFn is the loop over a namespace body: nested namespaces,
+// classes, structs, enums, using-declarations, templated or plain free
+// functions, and free variables, until the matching `}` closes it.
 func lexBlockFn(lex *lexer) stateFn {
+	lex.currentLoop = lexBlockFn
+	if lex.eof() {
+		return lex.errorf("Unexpected EOF in namespace body")
+	}
+	if lex.acceptSpace() {
+		lex.acceptRun(" \n\t")
+		lex.ignore()
+		return lexBlockFn
+	}
+	if next, ok := lexComment(lex, lexBlockFn); ok {
+		return next
+	}
+	if lex.acceptRune('}') {
+		lex.emit(itemCloseBrace)
+		return lex.popBlock()
+	}
+	if lex.acceptKeyword("template") {
+		lex.emit(itemTemplate)
+		return lexTemplateFn(lex, lexBlockFn)
+	}
 	if lex.acceptKeyword("namespace") {
 		lex.emit(itemNamespace)
+		lex.pushBlock(lexBlockFn)
 		return lexNamespaceFn(lex)
 	}
 	if lex.acceptKeyword("struct") {
 		lex.emit(itemStruct)
+		skipSpace(lex)
 		if lex.acceptIdentifier() {
 			lex.emit(itemIdentifier)
+			skipSpace(lex)
 		}
 		if !lex.acceptRune('{') {
 			return lex.errorf("Badly formed struct")
 		}
 		lex.emit(itemOpenBrace)
+		lex.pushBlock(lexBlockFn)
 		return lexStruct(lex)
 	}
 	if lex.acceptKeyword("class") {
 		lex.emit(itemClass)
+		skipSpace(lex)
 		if !lex.acceptIdentifier() {
 			return lex.errorf("Anonymous classes are illegal.")
 		}
 		lex.emit(itemIdentifier)
+		skipSpace(lex)
 		if !lex.acceptRune('{') {
 			return lex.errorf("Badly formed class")
 		}
 		lex.emit(itemOpenBrace)
+		lex.pushBlock(lexBlockFn)
 		return lexClass(lex)
 	}
 	if lex.acceptKeyword("enum") {
 		lex.emit(itemEnum)
+		skipSpace(lex)
+		if lex.acceptKeyword("class") || lex.acceptKeyword("struct") {
+			// A scoped enum ("enum class"/"enum struct"); the AST doesn't
+			// distinguish it from a plain enum, so the keyword itself is
+			// simply consumed rather than emitted as another itemEnum.
+			lex.ignore()
+			skipSpace(lex)
+		}
 		if !lex.acceptIdentifier() {
 			return lex.errorf("Anonymous enums are illegal.")
 		}
 		lex.emit(itemIdentifier)
+		skipSpace(lex)
 		if !lex.acceptRune('{') {
 			return lex.errorf("Badly formed enum")
 		}
 		lex.emit(itemOpenBrace)
+		lex.pushBlock(lexBlockFn)
 		return lexEnum(lex)
 	}
-	return lex.errorf("Expected namespace, struct, class, or enum.")
+	if lex.acceptKeyword("using") {
+		lex.emit(itemUsing)
+		return lexUsingFn(lex, lexBlockFn)
+	}
+	// Not a keyword this grammar recognizes as opening a new scope: try it
+	// as a free function or free variable declaration (e.g. the body a
+	// `template<...>` clause falls back into, per lexTemplateFn).
+	return lexDeclarationFn(lex, lexBlockFn)
+}
+
+// Upon entry we are just past a struct's opening `{` (already emitted).
+func lexStruct(lex *lexer) stateFn {
+	return lexMembersFn
+}
+
+// Upon entry we are just past a class's opening `{` (already emitted).
+func lexClass(lex *lexer) stateFn {
+	return lexMembersFn
+}
+
+// lexMembersFn is the loop over a class or struct body: access
+// specifiers, using-declarations, fields, and methods, until the matching
+// `}` (and optional instance name and `;`) closes it.
+func lexMembersFn(lex *lexer) stateFn {
+	lex.currentLoop = lexMembersFn
+	if lex.eof() {
+		return lex.errorf("Unexpected EOF in class or struct body")
+	}
+	if lex.acceptSpace() {
+		lex.acceptRun(" \n\t")
+		lex.ignore()
+		return lexMembersFn
+	}
+	if next, ok := lexComment(lex, lexMembersFn); ok {
+		return next
+	}
+	if lex.acceptRune('}') {
+		lex.emit(itemCloseBrace)
+		return lexCloseTypeFn(lex)
+	}
+	if lex.acceptKeyword("public") {
+		return lexAccessSpecifierFn(lex, itemPublic, lexMembersFn)
+	}
+	if lex.acceptKeyword("protected") {
+		return lexAccessSpecifierFn(lex, itemProtected, lexMembersFn)
+	}
+	if lex.acceptKeyword("private") {
+		return lexAccessSpecifierFn(lex, itemPrivate, lexMembersFn)
+	}
+	if lex.acceptKeyword("using") {
+		lex.emit(itemUsing)
+		return lexUsingFn(lex, lexMembersFn)
+	}
+	if lex.acceptKeyword("template") {
+		lex.emit(itemTemplate)
+		return lexTemplateFn(lex, lexMembersFn)
+	}
+	return lexDeclarationFn(lex, lexMembersFn)
+}
+
+// Upon entry we are just past an enum's opening `{` (already emitted).
+// lexEnum scans a comma-separated list of enumerator names, each with an
+// optional `= value` and an optional trailing `// ...` comment, until the
+// matching `}`.
+func lexEnum(lex *lexer) stateFn {
+	lex.currentLoop = lexEnum
+	if lex.eof() {
+		return lex.errorf("Unexpected EOF in enum body")
+	}
+	if lex.acceptSpace() {
+		lex.acceptRun(" \n\t")
+		lex.ignore()
+		return lexEnum
+	}
+	if next, ok := lexComment(lex, lexEnum); ok {
+		return next
+	}
+	if lex.acceptRune('}') {
+		lex.emit(itemCloseBrace)
+		return lexCloseTypeFn(lex)
+	}
+	if lex.acceptRune(',') {
+		lex.emit(itemSymbol)
+		return lexEnum
+	}
+	if !lex.acceptIdentifier() {
+		return lex.errorf("Expected enumerator name")
+	}
+	lex.emit(itemIdentifier)
+	lex.acceptRun(" \t")
+	lex.ignore()
+	if lex.acceptRune('=') {
+		lex.emit(itemEquals)
+		lex.acceptRun(" \t")
+		lex.ignore()
+		for {
+			switch lex.peek() {
+			case eof:
+				return lex.errorf("Unexpected EOF in enumerator value")
+			case ',', '}':
+				lex.emit(itemDefaultValue)
+				return lexEnum
+			}
+			lex.next()
+		}
+	}
+	return lexEnum
 }
 
+// Upon entry we've just emitted the itemCloseBrace closing a class,
+// struct, or enum body. lexCloseTypeFn accepts the optional instance-name
+// declarator before the closing `;`, e.g. "struct Foo { ... } instance;",
+// then resumes the enclosing scope's loop.
+func lexCloseTypeFn(lex *lexer) stateFn {
+	lex.acceptRun(" \t")
+	lex.ignore()
+	if lex.acceptIdentifier() {
+		lex.emit(itemIdentifier)
+		lex.acceptRun(" \t")
+		lex.ignore()
+	}
+	if !lex.acceptRune(';') {
+		return lex.errorf("Expected ';' after class, struct, or enum")
+	}
+	lex.emit(itemSemicolon)
+	resume := lex.popBlock()
+	return lexTrailingCommentFn(lex, resume)
+}
+
+// lexComment checks for a `/*` or `//` comment starting at the current
+// position. If found, it begins scanning it — resuming at resume once the
+// comment (and its itemDocComment/itemTrailingComment, if emitted) is
+// consumed — and returns (next state, true). Otherwise it returns
+// (nil, false) having left the lexer's position untouched.
+func lexComment(lex *lexer, resume stateFn) (stateFn, bool) {
+	if lex.acceptString("/*") {
+		lex.commentIsDoc = lex.peek() == '*' // `/**` opens a Doxygen-style doc comment
+		lex.commentResume = resume
+		return lexBlockCommentFn(lex), true
+	}
+	if lex.acceptString("//") {
+		lex.commentIsDoc = lex.peek() == '/' // `///` is a doc comment line
+		lex.commentResume = resume
+		return lexLineCommentFn(lex), true
+	}
+	return nil, false
+}
+
+// Upon entry we are just past `//` (or `///`); lex.commentIsDoc records which.
 func lexLineCommentFn(lex *lexer) stateFn {
 	if lex.eof() {
-		return nil
+		return lex.emitLineComment()
 	}
-	if lex.accept("\n") {
-		return lexRootFn
+	if lex.peek() == '\n' {
+		if lex.commentIsDoc && strings.HasPrefix(strings.TrimLeft(lex.input[lex.pos+1:], " \t"), "///") {
+			// The next line continues this run of `///` lines: keep
+			// scanning so the whole run becomes one itemDocComment.
+			lex.next()
+			return lexLineCommentFn
+		}
+		next := lex.emitLineComment()
+		lex.next()
+		lex.ignore()
+		return next
 	}
+	lex.next()
 	return lexLineCommentFn
 }
 
+// emitLineComment emits the `//` or `///` comment just scanned as a doc or
+// trailing comment item, unless comment emission is disabled or it is a
+// plain `//` comment alone on its line, then resumes at lex.commentResume.
+func (lex *lexer) emitLineComment() stateFn {
+	switch {
+	case !lex.emitComments:
+		lex.ignore()
+	case lex.commentIsDoc:
+		lex.emit(itemDocComment)
+	case strings.TrimSpace(lex.input[lex.lineStart:lex.start]) == "":
+		// Nothing precedes it on its line, so it trails no declaration.
+		lex.ignore()
+	default:
+		lex.emit(itemTrailingComment)
+	}
+	return lex.commentResume
+}
+
 func lexEatLineFn(lex *lexer) stateFn {
 	if lex.eof() {
+		lex.emit(itemEOF)
 		return nil
 	}
-	if lex.accept("\n") {
+	if lex.peek() == '\n' {
+		lex.next()
 		return lexRootFn
 	}
+	lex.next()
 	return lexEatLineFn
 }
 
+// Upon entry we are just past `/*` (or `/**`); lex.commentIsDoc records
+// which, and lex.commentResume records where to continue once the comment
+// (and its itemDocComment, if emitted) is consumed.
 func lexBlockCommentFn(lex *lexer) stateFn {
 	if lex.eof() {
-		return lex.errorf("Unexpected EOF")
+		return lex.errorf("Unexpected EOF in block comment")
 	}
-	if lex.accept("*/") {
-		return lexRootFn
+	// lex.accept("*/") would treat "*/" as the character set {'*', '/'},
+	// matching either rune individually instead of the literal two-rune
+	// sequence — which both truncates every `/** ... */` doc comment to
+	// "/**" and, since a mismatch backs up and makes no progress, hangs on
+	// any comment body character that isn't '*' or '/'. Check the literal
+	// sequence instead.
+	if strings.HasPrefix(lex.input[lex.pos:], "*/") {
+		lex.pos += 2
+		if lex.emitComments && lex.commentIsDoc {
+			lex.emit(itemDocComment)
+		} else {
+			lex.ignore()
+		}
+		return lex.commentResume
 	}
+	lex.next()
 	return lexBlockCommentFn
 }
 
+// Upon entry we are just past the `;` ending a declaration (a method with
+// an inline body resumes its scope's loop directly instead). lexTrailingCommentFn checks for a `// ...` comment on the
+// same line, emitting it as an itemTrailingComment if present, then
+// resumes at resume either way.
+func lexTrailingCommentFn(lex *lexer, resume stateFn) stateFn {
+	if lex.accept(" \t") {
+		lex.acceptRun(" \t")
+	}
+	lex.ignore()
+	if lex.acceptString("//") {
+		lex.commentIsDoc = false
+		lex.commentResume = resume
+		return lexLineCommentFn(lex)
+	}
+	lex.ignore()
+	return resume
+}
+
+// Upon entry we are just past the public/protected/private keyword, which
+// is emitted here as kind.
+func lexAccessSpecifierFn(lex *lexer, kind itemType, resume stateFn) stateFn {
+	lex.emit(kind)
+	skipSpace(lex)
+	if !lex.acceptRune(':') {
+		return lex.errorf("Expected ':' after access specifier")
+	}
+	lex.emit(itemColon)
+	return resume
+}
+
+// Upon entry we are just past the `using` keyword (already emitted).
+// lexUsingFn scans "NAME = RHS;" and resumes the enclosing scope's loop.
+func lexUsingFn(lex *lexer, resume stateFn) stateFn {
+	lex.acceptRun(" \t")
+	lex.ignore()
+	if !lex.acceptIdentifier() {
+		return lex.errorf("Expected identifier after 'using'")
+	}
+	lex.emit(itemIdentifier)
+	lex.acceptRun(" \t")
+	lex.ignore()
+	if !lex.acceptRune('=') {
+		return lex.errorf("Expected '=' in using-declaration")
+	}
+	lex.emit(itemEquals)
+	lex.acceptRun(" \t")
+	lex.ignore()
+	for {
+		switch lex.peek() {
+		case eof:
+			return lex.errorf("Unexpected EOF in using-declaration")
+		case ';':
+			lex.emit(itemDefaultValue)
+			lex.next()
+			lex.emit(itemSemicolon)
+			return lexTrailingCommentFn(lex, resume)
+		}
+		lex.next()
+	}
+}
+
+// Upon entry we are just past the `template` keyword (already emitted).
+// lexTemplateFn consumes the balanced `<...>` parameter list that follows,
+// emits it as a single itemTemplateArgs blob, and falls back into resume
+// so the class, struct, or method/function the template clause introduces
+// is lexed normally — resume is lexBlockFn for a templated class, struct,
+// or free function, and lexMembersFn for a templated method.
+func lexTemplateFn(lex *lexer, resume stateFn) stateFn {
+	lex.acceptRun(" \n\t")
+	lex.ignore()
+	if !lex.acceptRune('<') {
+		return lex.errorf("Expected '<' after 'template'")
+	}
+	lex.angleBracketDepth = 1
+	for lex.angleBracketDepth > 0 {
+		switch lex.next() {
+		case eof:
+			return lex.errorf("Unexpected EOF in template parameter list")
+		case '<':
+			lex.angleBracketDepth++
+		case '>':
+			lex.angleBracketDepth--
+		}
+	}
+	lex.emit(itemTemplateArgs)
+	lex.angleBracketDepth = 0
+	return resume
+}
+
+// lexDeclarationFn scans a field, free variable, or method declaration —
+// "<type> <name>" followed by either "(<args>) [const] [noexcept] {<body>}"
+// or "(<args>) [const] [noexcept];" for a method, or "[= <rhs>];" for a
+// field or free variable — then resumes the enclosing scope's loop.
+func lexDeclarationFn(lex *lexer, resume stateFn) stateFn {
+	if !lexTypeAndName(lex) {
+		return lex.errorf("Expected a declaration")
+	}
+	lex.acceptRun(" \t\n")
+	lex.ignore()
+	if lex.peek() == '(' {
+		return lexMethodFn(lex, resume)
+	}
+	if lex.acceptRune('=') {
+		lex.emit(itemEquals)
+		return lexFieldRhsFn(lex, resume)
+	}
+	if lex.acceptRune(';') {
+		lex.emit(itemSemicolon)
+		return lexTrailingCommentFn(lex, resume)
+	}
+	return lex.errorf("Expected '(', '=', or ';' in declaration")
+}
+
+// lexTypeAndName scans the raw "<type> <name>" prefix of a declaration up
+// to (not including) the '(', '=', or ';' that follows the name, then
+// splits and emits it as an itemSimpleType/itemIdentifier pair.
+func lexTypeAndName(lex *lexer) bool {
+	blobStart := lex.pos
+	for {
+		switch lex.peek() {
+		case eof, '{', '}':
+			return false
+		case '(', '=', ';':
+			return lex.emitTypeAndName(blobStart)
+		}
+		lex.next()
+	}
+}
+
+// emitTypeAndName splits input[blobStart:lex.pos] into its type and
+// trailing identifier name via splitTypeAndName and emits them as
+// itemSimpleType and itemIdentifier respectively.
+func (lex *lexer) emitTypeAndName(blobStart int) bool {
+	blob := lex.input[blobStart:lex.pos]
+	typ, name, ok := splitTypeAndName(blob)
+	if !ok {
+		return false
+	}
+	nameStart := blobStart + strings.LastIndex(blob, name)
+	nameLine := lex.startLine + strings.Count(lex.input[blobStart:nameStart], "\n")
+	nameCol := nameStart - (strings.LastIndex(lex.input[:nameStart], "\n") + 1)
+	lex.items <- item{itemSimpleType, blobStart, nameStart, typ, lex.startLine, lex.startCol}
+	lex.items <- item{itemIdentifier, nameStart, lex.pos, name, nameLine, nameCol}
+	lex.start = lex.pos
+	lex.startLine = lex.line
+	lex.startCol = lex.start - lex.lineStart
+	return true
+}
+
+// splitTypeAndName splits a declarator blob such as "Texture* const tex"
+// into its type ("Texture* const") and trailing identifier name ("tex").
+func splitTypeAndName(blob string) (typ, name string, ok bool) {
+	trimmed := strings.TrimSpace(blob)
+	i := len(trimmed)
+	for i > 0 && isAlphaNumeric(rune(trimmed[i-1])) {
+		i--
+	}
+	name = trimmed[i:]
+	typ = strings.TrimSpace(trimmed[:i])
+	if name == "" || typ == "" {
+		return "", "", false
+	}
+	return typ, name, true
+}
+
+// Upon entry we are just before the '(' opening a method's argument list.
+// lexMethodFn scans the balanced argument list, optional trailing
+// `const`/`noexcept`, and either its `;` (declaration only) or its
+// `{ ... }` inline body, then resumes the enclosing scope's loop.
+func lexMethodFn(lex *lexer, resume stateFn) stateFn {
+	if !lexBalanced(lex, '(', ')') {
+		return lex.errorf("Unterminated method argument list")
+	}
+	lex.emit(itemMethodArgs)
+	lex.acceptRun(" \t\n")
+	lex.ignore()
+	if lex.acceptKeyword("const") {
+		lex.emit(itemConst)
+		lex.acceptRun(" \t\n")
+		lex.ignore()
+	}
+	if lex.acceptKeyword("noexcept") {
+		lex.emit(itemNoexcept)
+		lex.acceptRun(" \t\n")
+		lex.ignore()
+	}
+	if lex.acceptRune(';') {
+		lex.emit(itemSemicolon)
+		return lexTrailingCommentFn(lex, resume)
+	}
+	if lex.peek() == '{' {
+		if !lexBalanced(lex, '{', '}') {
+			return lex.errorf("Unterminated method body")
+		}
+		lex.emit(itemMethodBody)
+		return resume
+	}
+	return lex.errorf("Expected ';' or method body")
+}
+
+// lexBalanced scans a balanced open/close pair starting at the current
+// position (which must hold open), advancing past the matching close.
+func lexBalanced(lex *lexer, open, close rune) bool {
+	if lex.next() != open {
+		lex.backup()
+		return false
+	}
+	depth := 1
+	for depth > 0 {
+		switch lex.next() {
+		case eof:
+			return false
+		case open:
+			depth++
+		case close:
+			depth--
+		}
+	}
+	return true
+}
+
+// Upon entry we are just past the '=' (already emitted) of a field or
+// free variable's initializer. lexFieldRhsFn scans the raw RHS expression
+// up to ';', then resumes the enclosing scope's loop.
+func lexFieldRhsFn(lex *lexer, resume stateFn) stateFn {
+	lex.acceptRun(" \t")
+	lex.ignore()
+	for {
+		switch lex.peek() {
+		case eof:
+			return lex.errorf("Unexpected EOF in default value")
+		case ';':
+			lex.emit(itemDefaultValue)
+			lex.next()
+			lex.emit(itemSemicolon)
+			return lexTrailingCommentFn(lex, resume)
+		}
+		lex.next()
+	}
+}
+
 func lexSymbolFn(lex *lexer) stateFn {
 	switch lex.input[lex.pos] {
 	case '{':
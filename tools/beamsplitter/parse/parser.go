@@ -0,0 +1,565 @@
+/*
+ * Copyright (C) 2022 The Android Open Source Project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import "strings"
+
+// Parse lexes and parses name/input into a RootNode. Errors no longer abort
+// the whole file: every Diagnostic encountered, including recoverable ones
+// resynced past by errorf, is collected and returned alongside whatever AST
+// could still be built, so callers can surface every problem in a header in
+// a single run instead of one error at a time.
+//
+// Before lexing, input runs through a preprocess pass that resolves
+// `#include`, expands object-like `#define`s, and drops branches of
+// `#if`/`#ifdef` that evaluate false against the Defines/WithIncludeResolver
+// Options; pass PreservePreprocessor(true) to also record directives as
+// PreprocessorNodes on the returned RootNode (see PreprocessorNode for which
+// ones are kept). The filtering and expansion apply either way.
+func Parse(name, input string, opts ...Option) (*RootNode, []Diagnostic) {
+	cfg := &lexer{}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	var diags []Diagnostic
+	preprocessed, preprocNodes, err := preprocess(name, input, cfg.defines, cfg.includeResolver, cfg.preservePreprocessor)
+	if err != nil {
+		diags = append(diags, Diagnostic{Msg: err.Error(), Severity: SeverityError})
+	}
+
+	opts = append(opts, withHandler(func(d Diagnostic) {
+		diags = append(diags, d)
+	}))
+	l := lex(name, preprocessed, opts...)
+	defer l.drain()
+
+	p := &parser{lex: l}
+	root := &RootNode{NodeType: NodeRoot, Preprocessor: preprocNodes}
+	root.Child = p.parseRoot()
+	return root, diags
+}
+
+// parser consumes the item stream produced by a lexer and assembles it into
+// an AST. It keeps at most one item of lookahead, since a declaration's
+// trailing `// ...` comment (if any) is only known to belong to it once the
+// token after the declaration's own tokens has been seen.
+type parser struct {
+	lex    *lexer
+	peeked *item
+
+	// lastCloseBrace is set by parseBlockBody/parseMembers to the
+	// final item they consumed (the closing `}`, or whatever trailing
+	// instance-name/`;`/comment followed it), so the caller that opened the
+	// scope can compute its own Position without threading an extra return
+	// value through every one of them.
+	lastCloseBrace item
+
+	// lastInstanceName is set by afterCloseType to the instance-name
+	// identifier following a `}`, if any (e.g. "struct Foo { ... } bar;"),
+	// and cleared otherwise, so parseStruct can read it after parseMembers.
+	lastInstanceName string
+}
+
+// nextItem returns the next item, consuming the lookahead buffer if peek
+// has been called since the last nextItem.
+func (p *parser) nextItem() item {
+	if p.peeked != nil {
+		it := *p.peeked
+		p.peeked = nil
+		return it
+	}
+	return p.lex.nextItem()
+}
+
+// peek returns the next item without consuming it.
+func (p *parser) peek() item {
+	if p.peeked == nil {
+		it := p.lex.nextItem()
+		p.peeked = &it
+	}
+	return *p.peeked
+}
+
+func posFromItems(start, end item) Position {
+	return Position{startOffset: start.pos, endOffset: end.endPos, column: start.col}
+}
+
+// isBoundary reports whether it marks a point past which a parse* function
+// must not keep reading as if it were still inside the construct it's
+// building: real end of input, or a recoverable lexer error (itemError,
+// itemRecovered). The lexer may have already resynced past an arbitrary
+// amount of input by the time this item is seen, so treating it as
+// ordinary content would attribute whatever comes next to the wrong node.
+//
+// This deliberately differs from the itemCloseBrace/itemEOF/itemError
+// cases in parseRoot/parseBlockBody/parseMembers/parseEnum: those are
+// reading "the next declaration in this scope" and must NOT stop on
+// itemRecovered, since lexing continues within the same scope after a
+// resync. isBoundary is for functions mid-way through a single
+// declaration, where any of these three means "stop, this declaration is
+// unrecoverable" rather than "the scope is over."
+func isBoundary(it item) bool {
+	return it.typ == itemEOF || it.typ == itemError || it.typ == itemRecovered
+}
+
+// parseRoot consumes items up to itemEOF, returning the first top-level
+// namespace found (RootNode has room for only one, matching the schema
+// every other part of this package already assumes).
+func (p *parser) parseRoot() *NamespaceNode {
+	var first *NamespaceNode
+	for {
+		it := p.nextItem()
+		switch it.typ {
+		case itemEOF, itemError:
+			return first
+		case itemNamespace:
+			ns := p.parseNamespace(it)
+			if first == nil {
+				first = ns
+			}
+		}
+	}
+}
+
+// Upon entry, kw is the already-consumed itemNamespace token.
+func (p *parser) parseNamespace(kw item) *NamespaceNode {
+	n := &NamespaceNode{NodeType: NodeNamespace}
+	it := p.nextItem()
+	if it.typ == itemIdentifier {
+		n.Name = it.val
+		it = p.nextItem()
+	}
+	if isBoundary(it) {
+		// See the matching check in parseStruct: lexNamespaceFn never
+		// reached this namespace's opening '{', so there's no body to
+		// read, and calling parseBlockBody anyway would attribute
+		// whatever the lexer resynced onto to this broken namespace.
+		n.Line = Line(kw.line)
+		n.Position = posFromItems(kw, it)
+		return n
+	}
+	// it.typ == itemOpenBrace here.
+	n.Line = Line(kw.line)
+	n.Children = p.parseBlockBody()
+	end := p.lastCloseBrace
+	n.Position = posFromItems(kw, end)
+	return n
+}
+
+// parseBlockBody parses the contents of a namespace body (nested
+// namespaces, classes, structs, enums, using-declarations, and free
+// functions/variables) up to and including its closing `}`.
+func (p *parser) parseBlockBody() []Node {
+	var doc string
+	var children []Node
+	for {
+		it := p.nextItem()
+		switch it.typ {
+		case itemCloseBrace, itemEOF, itemError:
+			p.lastCloseBrace = it
+			return children
+		case itemDocComment:
+			doc = it.val
+		case itemTemplate:
+			argsItem := p.nextItem() // itemTemplateArgs
+			if argsItem.typ != itemTemplateArgs {
+				// lexTemplateFn hit a recoverable error (e.g. no '<' after
+				// 'template') and resynced without ever emitting
+				// itemTemplateArgs: argsItem is actually the next real
+				// token, not template-parameter text. Put it back and
+				// let the top of the loop dispatch it normally instead of
+				// misreading it as part of this template clause.
+				p.peeked = &argsItem
+				continue
+			}
+			params := parseTemplateParams(argsItem.val)
+			next := p.nextItem()
+			switch next.typ {
+			case itemNamespace:
+				children = append(children, p.parseNamespace(next))
+				doc = ""
+			case itemStruct:
+				s := p.parseStruct(next)
+				s.DocComment, doc = doc, ""
+				s.TemplateParameters, s.TemplateParams = argsItem.val, params
+				children = append(children, s)
+			case itemClass:
+				c := p.parseClass(next)
+				c.DocComment, doc = doc, ""
+				c.TemplateParameters, c.TemplateParams = argsItem.val, params
+				children = append(children, c)
+			case itemSimpleType:
+				m := p.parseDeclaration(next)
+				if method, ok := m.(*MethodNode); ok {
+					method.DocComment, doc = doc, ""
+					method.IsTemplate = true
+					method.TemplateParameters, method.TemplateParams = argsItem.val, params
+				}
+				children = append(children, m)
+			}
+		case itemNamespace:
+			children = append(children, p.parseNamespace(it))
+		case itemStruct:
+			s := p.parseStruct(it)
+			s.DocComment, doc = doc, ""
+			children = append(children, s)
+		case itemClass:
+			c := p.parseClass(it)
+			c.DocComment, doc = doc, ""
+			children = append(children, c)
+		case itemEnum:
+			e := p.parseEnum(it)
+			e.DocComment, doc = doc, ""
+			children = append(children, e)
+		case itemUsing:
+			children = append(children, p.parseUsing(it))
+		case itemSimpleType:
+			d := p.parseDeclaration(it)
+			switch n := d.(type) {
+			case *FieldNode:
+				n.DocComment, doc = doc, ""
+			case *MethodNode:
+				n.DocComment, doc = doc, ""
+			}
+			children = append(children, d)
+		}
+	}
+}
+
+// parseStruct and parseClass share everything but the trailing instance
+// name (legal after `struct { ... } instance;`, not after a class) and
+// their NodeType, so each is a thin wrapper over the shared member-body
+// scan.
+//
+// Upon entry, kw is the already-consumed itemStruct token.
+func (p *parser) parseStruct(kw item) *StructNode {
+	s := &StructNode{NodeType: NodeStruct, Line: Line(kw.line)}
+	it := p.nextItem()
+	if it.typ == itemIdentifier {
+		s.Name = it.val
+		it = p.nextItem()
+	}
+	if isBoundary(it) {
+		// The lexer never reached this struct's opening '{' (it resynced
+		// past a malformed declaration first): there's no member body to
+		// read, and calling parseMembers anyway would consume whatever
+		// well-formed declaration comes next as if it belonged here.
+		s.Position = posFromItems(kw, it)
+		return s
+	}
+	s.Members = p.parseMembers()
+	s.InstanceName = p.lastInstanceName
+	s.Position = posFromItems(kw, p.lastCloseBrace)
+	return s
+}
+
+// Upon entry, kw is the already-consumed itemClass token.
+func (p *parser) parseClass(kw item) *ClassNode {
+	c := &ClassNode{NodeType: NodeClass, Line: Line(kw.line)}
+	it := p.nextItem()
+	if it.typ == itemIdentifier {
+		c.Name = it.val
+		it = p.nextItem()
+	}
+	if isBoundary(it) {
+		// See the matching check in parseStruct: no '{' was ever reached,
+		// so there's no member body to read.
+		c.Position = posFromItems(kw, it)
+		return c
+	}
+	c.Members = p.parseMembers()
+	// ClassNode has no InstanceName field, unlike StructNode: the lexer
+	// doesn't distinguish which type this trailing declarator follows, but
+	// the AST schema only models it for structs, so a stray one here is
+	// simply dropped.
+	c.Position = posFromItems(kw, p.lastCloseBrace)
+	return c
+}
+
+// parseMembers parses the body of a class or struct (access specifiers,
+// using-declarations, fields, and methods) up to and including its
+// closing `}` and optional trailing instance-name/`;`.
+func (p *parser) parseMembers() []Node {
+	var doc string
+	var members []Node
+	for {
+		it := p.nextItem()
+		switch it.typ {
+		case itemCloseBrace, itemEOF, itemError:
+			p.lastCloseBrace = p.afterCloseType(it)
+			return members
+		case itemDocComment:
+			doc = it.val
+		case itemTemplate:
+			argsItem := p.nextItem() // itemTemplateArgs
+			if argsItem.typ != itemTemplateArgs {
+				// See the matching check in parseBlockBody.
+				p.peeked = &argsItem
+				continue
+			}
+			params := parseTemplateParams(argsItem.val)
+			next := p.nextItem()
+			if next.typ == itemSimpleType {
+				m := p.parseDeclaration(next)
+				if method, ok := m.(*MethodNode); ok {
+					method.DocComment, doc = doc, ""
+					method.IsTemplate = true
+					method.TemplateParameters, method.TemplateParams = argsItem.val, params
+				}
+				members = append(members, m)
+			}
+		case itemPublic, itemProtected, itemPrivate:
+			members = append(members, p.parseAccessSpecifier(it))
+		case itemUsing:
+			members = append(members, p.parseUsing(it))
+		case itemSimpleType:
+			f := p.parseDeclaration(it)
+			switch n := f.(type) {
+			case *FieldNode:
+				n.DocComment, doc = doc, ""
+			case *MethodNode:
+				n.DocComment, doc = doc, ""
+			}
+			members = append(members, f)
+		}
+	}
+}
+
+// afterCloseType consumes the optional instance-name identifier and
+// trailing comment the lexer emits after a class/struct/enum's closing
+// `}` (see lexCloseTypeFn), returning the outermost token so the caller
+// can still report an accurate end Position.
+func (p *parser) afterCloseType(closeBrace item) item {
+	p.lastInstanceName = ""
+	if closeBrace.typ != itemCloseBrace {
+		return closeBrace
+	}
+	end := closeBrace
+	next := p.peek()
+	if next.typ == itemIdentifier {
+		end = next
+		p.lastInstanceName = next.val
+		p.nextItem()
+		next = p.peek()
+	}
+	if next.typ == itemSemicolon {
+		end = next
+		p.nextItem()
+	}
+	if p.peek().typ == itemTrailingComment {
+		end = p.nextItem()
+	}
+	return end
+}
+
+// Upon entry, kw is the already-consumed itemEnum token.
+func (p *parser) parseEnum(kw item) *EnumNode {
+	e := &EnumNode{NodeType: NodeEnum, Line: Line(kw.line)}
+	it := p.nextItem()
+	if it.typ == itemIdentifier {
+		e.Name = it.val
+		it = p.nextItem()
+	}
+	if isBoundary(it) {
+		// See the matching check in parseStruct: no '{' was ever reached.
+		e.Position = posFromItems(kw, it)
+		return e
+	}
+	var cur *EnumValue
+	end := it
+	for {
+		it := p.nextItem()
+		switch it.typ {
+		case itemCloseBrace, itemEOF, itemError:
+			end = p.afterCloseType(it)
+			e.Position = posFromItems(kw, end)
+			return e
+		case itemIdentifier:
+			e.Values = append(e.Values, EnumValue{Name: it.val})
+			cur = &e.Values[len(e.Values)-1]
+		case itemTrailingComment:
+			if cur != nil {
+				cur.TrailingComment = it.val
+			}
+		}
+	}
+}
+
+// Upon entry, kw is the already-consumed itemUsing token.
+func (p *parser) parseUsing(kw item) *UsingNode {
+	n := &UsingNode{NodeType: NodeUsing, Line: Line(kw.line)}
+	end := kw
+	for {
+		it := p.nextItem()
+		switch it.typ {
+		case itemIdentifier:
+			n.Name = it.val
+		case itemEquals:
+			// Separates the alias name from its RHS; nothing to record.
+		case itemDefaultValue:
+			n.Rhs = it.val
+		case itemSemicolon:
+			end = it
+			if p.peek().typ == itemTrailingComment {
+				end = p.nextItem()
+			}
+			n.Position = posFromItems(kw, end)
+			return n
+		default:
+			n.Position = posFromItems(kw, end)
+			return n
+		}
+	}
+}
+
+// Upon entry, kw is the already-consumed access specifier token.
+func (p *parser) parseAccessSpecifier(kw item) *AccessSpecifierNode {
+	n := &AccessSpecifierNode{NodeType: NodeAccessSpecifier, Line: Line(kw.line), Access: kw.val}
+	end := kw
+	if it := p.peek(); it.typ == itemColon {
+		end = p.nextItem()
+	}
+	n.Position = posFromItems(kw, end)
+	return n
+}
+
+// parseDeclaration builds a MethodNode (if followed by an itemMethodArgs
+// blob) or a FieldNode from the itemSimpleType/itemIdentifier pair typ and
+// whatever follows it.
+//
+// Upon entry, typ is the already-consumed itemSimpleType token; the
+// itemIdentifier naming the declaration follows immediately.
+func (p *parser) parseDeclaration(typ item) Node {
+	name := p.nextItem() // itemIdentifier
+	if isBoundary(name) {
+		return &FieldNode{NodeType: NodeField, Line: Line(typ.line), FieldType: typ.val, Position: posFromItems(typ, name)}
+	}
+	end := name
+	var method *MethodNode
+	var field *FieldNode
+	for {
+		it := p.nextItem()
+		switch it.typ {
+		case itemMethodArgs:
+			if method == nil {
+				method = &MethodNode{NodeType: NodeMethod, Line: Line(typ.line), Name: name.val, ReturnType: typ.val}
+			}
+			method.Arguments = it.val
+		case itemMethodBody:
+			method.Body = it.val
+			end = it
+			method.Position = posFromItems(typ, end)
+			return method
+		case itemDefaultValue:
+			if field == nil {
+				field = &FieldNode{NodeType: NodeField, Line: Line(typ.line), Name: name.val, FieldType: typ.val}
+			}
+			field.Rhs = it.val
+		case itemSemicolon:
+			end = it
+			if p.peek().typ == itemTrailingComment {
+				end = p.nextItem()
+			}
+			if method != nil {
+				// MethodNode has no TrailingComment field: a comment
+				// trailing a method declaration is lexed (see
+				// lexTrailingCommentFn) but has nowhere to attach, so it
+				// is simply dropped.
+				method.Position = posFromItems(typ, end)
+				return method
+			}
+			if field == nil {
+				field = &FieldNode{NodeType: NodeField, Line: Line(typ.line), Name: name.val, FieldType: typ.val}
+			}
+			if end.typ == itemTrailingComment {
+				field.TrailingComment = end.val
+			}
+			field.Position = posFromItems(typ, end)
+			return field
+		case itemEOF, itemError, itemRecovered:
+			if method != nil {
+				method.Position = posFromItems(typ, end)
+				return method
+			}
+			if field == nil {
+				field = &FieldNode{NodeType: NodeField, Line: Line(typ.line), Name: name.val, FieldType: typ.val}
+			}
+			field.Position = posFromItems(typ, end)
+			return field
+		}
+	}
+}
+
+// parseTemplateParams splits the raw itemTemplateArgs text of a
+// `template<...>` clause (including its outer `<` and `>`) into structured
+// TemplateParams, e.g. "<typename T, int N = 4>" ->
+// [{Kind: "typename", Name: "T"}, {Kind: "int", Name: "N", Default: "4"}].
+func parseTemplateParams(raw string) []TemplateParam {
+	raw = strings.TrimSpace(raw)
+	raw = strings.TrimPrefix(raw, "<")
+	raw = strings.TrimSuffix(raw, ">")
+	var params []TemplateParam
+	for _, part := range splitTopLevel(raw, ',') {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		def := ""
+		if i := strings.Index(part, "="); i >= 0 {
+			def = strings.TrimSpace(part[i+1:])
+			part = strings.TrimSpace(part[:i])
+		}
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		kind := fields[0]
+		name := ""
+		if len(fields) > 1 {
+			kind = strings.Join(fields[:len(fields)-1], " ")
+			name = fields[len(fields)-1]
+		}
+		params = append(params, TemplateParam{Name: name, Kind: kind, Default: def})
+	}
+	return params
+}
+
+// splitTopLevel splits s on sep, ignoring occurrences nested inside `<>` or
+// `()`, so "T, array<U, 2>" splits into ["T", " array<U, 2>"] rather than
+// four pieces, and a default value like "N = f(1, 2)" keeps its call's
+// arguments together instead of being split into extra bogus parameters.
+func splitTopLevel(s string, sep rune) []string {
+	var parts []string
+	depth := 0
+	last := 0
+	for i, r := range s {
+		switch r {
+		case '<', '(':
+			depth++
+		case '>', ')':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+	parts = append(parts, s[last:])
+	return parts
+}
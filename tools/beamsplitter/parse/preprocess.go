@@ -0,0 +1,482 @@
+/*
+ * Copyright (C) 2022 The Android Open Source Project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// IncludeResolver resolves the quoted or angle-bracketed path of an
+// `#include` directive to the contents of the file it names.
+type IncludeResolver func(path string) (string, error)
+
+// PreprocessorNode records a single directive line (`#include`, `#if`, ...)
+// that survived preprocessing because PreservePreprocessor was set, rather
+// than being silently consumed once its effect (inclusion, macro expansion,
+// conditional filtering) was applied to the token stream. Structural
+// conditional directives (`#if`/`#ifdef`/`#ifndef`/`#elif`/`#else`/`#endif`)
+// are always preserved, even inside a branch that isn't active, so a
+// consumer can reconstruct the full nesting; other directives are preserved
+// only where they actually took effect.
+type PreprocessorNode struct {
+	NodeType
+	Line
+	Position
+	Directive string // "include", "define", "if", "ifdef", ...
+	Args      string // the raw text following the directive keyword
+}
+
+// PreservePreprocessor keeps preprocessor directives as PreprocessorNodes on
+// the RootNode instead of discarding them once they have been acted on:
+// every conditional directive, and every other directive in an active
+// branch. Defaults to false.
+func PreservePreprocessor(preserve bool) Option {
+	return func(l *lexer) {
+		l.preservePreprocessor = preserve
+	}
+}
+
+// Defines supplies the initial macro table that `#if`/`#ifdef` conditionals
+// and object-like macro expansions are evaluated against.
+func Defines(defines map[string]string) Option {
+	return func(l *lexer) {
+		l.defines = defines
+	}
+}
+
+// WithIncludeResolver supplies the callback used to resolve `#include`
+// paths to file contents. Without one, `#include` lines are left
+// unresolved: dropped, or kept as a PreprocessorNode if PreservePreprocessor
+// is set.
+func WithIncludeResolver(resolve IncludeResolver) Option {
+	return func(l *lexer) {
+		l.includeResolver = resolve
+	}
+}
+
+// condBranch tracks one level of `#if`/`#ifdef`/`#ifndef` nesting: whether
+// its current branch is active, and whether some branch in this chain has
+// already been taken (so a later `#elif`/`#else` knows not to activate).
+type condBranch struct {
+	active bool
+	taken  bool
+}
+
+// preprocess runs the `#include`/`#define`/`#if` pass ahead of the main
+// lexer. It is line-oriented rather than fully tokenizing, since the
+// directives it understands are themselves always whole lines; this keeps
+// it independent of the lexer's own token stream. It returns the filtered,
+// macro-expanded source the lexer should scan, plus the PreprocessorNodes
+// preserved along the way.
+func preprocess(name, input string, defines map[string]string, resolve IncludeResolver, preserve bool) (string, []PreprocessorNode, error) {
+	return preprocessFile(name, input, defines, resolve, preserve, map[string]bool{})
+}
+
+// preprocessFile is preprocess's recursive worker. including tracks the
+// chain of files currently being expanded via #include, so an #include
+// cycle (a file that includes itself, directly or through other headers)
+// is reported as an error instead of recursing until the stack overflows.
+// The check runs before the included file's own conditionals are
+// evaluated, so a cycle is reported even where include guards would have
+// cut it short.
+func preprocessFile(name, input string, defines map[string]string, resolve IncludeResolver, preserve bool, including map[string]bool) (string, []PreprocessorNode, error) {
+	if including[name] {
+		return "", nil, fmt.Errorf("#include cycle detected at %q", name)
+	}
+	including[name] = true
+	defer delete(including, name)
+
+	merged := make(map[string]string, len(defines))
+	for k, v := range defines {
+		merged[k] = v
+	}
+
+	var out strings.Builder
+	var nodes []PreprocessorNode
+	var stack []condBranch
+
+	active := func() bool {
+		for _, c := range stack {
+			if !c.active {
+				return false
+			}
+		}
+		return true
+	}
+	parentActive := func() bool {
+		for _, c := range stack[:len(stack)-1] {
+			if !c.active {
+				return false
+			}
+		}
+		return true
+	}
+
+	for lineNo, rawLine := range strings.Split(input, "\n") {
+		lineNo++
+		trimmed := strings.TrimSpace(rawLine)
+		if !strings.HasPrefix(trimmed, "#") {
+			if active() {
+				out.WriteString(expandDefines(rawLine, merged))
+			}
+			out.WriteByte('\n')
+			continue
+		}
+
+		directive, args := splitDirective(trimmed[1:])
+		wasActive := active()
+		switch directive {
+		case "ifdef":
+			_, ok := merged[strings.TrimSpace(args)]
+			stack = append(stack, condBranch{active: wasActive && ok, taken: ok})
+		case "ifndef":
+			_, ok := merged[strings.TrimSpace(args)]
+			stack = append(stack, condBranch{active: wasActive && !ok, taken: !ok})
+		case "if":
+			ok, err := evalCondition(args, merged)
+			if err != nil {
+				return out.String(), nodes, fmt.Errorf("%s:%d: %w", name, lineNo, err)
+			}
+			stack = append(stack, condBranch{active: wasActive && ok, taken: wasActive && ok})
+		case "elif":
+			if len(stack) == 0 {
+				return out.String(), nodes, fmt.Errorf("%s:%d: #elif without #if", name, lineNo)
+			}
+			top := &stack[len(stack)-1]
+			if !top.taken && parentActive() {
+				ok, err := evalCondition(args, merged)
+				if err != nil {
+					return out.String(), nodes, fmt.Errorf("%s:%d: %w", name, lineNo, err)
+				}
+				top.active = ok
+				top.taken = top.taken || ok
+			} else {
+				top.active = false
+			}
+		case "else":
+			if len(stack) == 0 {
+				return out.String(), nodes, fmt.Errorf("%s:%d: #else without #if", name, lineNo)
+			}
+			top := &stack[len(stack)-1]
+			top.active = !top.taken && parentActive()
+			top.taken = true
+		case "endif":
+			if len(stack) == 0 {
+				return out.String(), nodes, fmt.Errorf("%s:%d: #endif without #if", name, lineNo)
+			}
+			stack = stack[:len(stack)-1]
+		case "define":
+			if wasActive {
+				macro, value := splitDefine(args)
+				merged[macro] = value
+			}
+		case "undef":
+			if wasActive {
+				delete(merged, strings.TrimSpace(args))
+			}
+		case "include":
+			if wasActive {
+				path, ok := includePath(args)
+				if ok && resolve != nil {
+					contents, err := resolve(path)
+					if err != nil {
+						return out.String(), nodes, fmt.Errorf("%s:%d: #include %q: %w", name, lineNo, path, err)
+					}
+					included, includedNodes, err := preprocessFile(path, contents, merged, resolve, preserve, including)
+					if err != nil {
+						return out.String(), nodes, err
+					}
+					out.WriteString(included)
+					if preserve {
+						nodes = append(nodes, PreprocessorNode{NodeType: NodePreprocessor, Line: Line(lineNo), Directive: directive, Args: strings.TrimSpace(args)})
+					}
+					nodes = append(nodes, includedNodes...)
+					continue
+				}
+			}
+		case "pragma":
+			// Not acted on; only recorded as a PreprocessorNode when preserve is set.
+		}
+
+		if preserve && (wasActive || isStructuralDirective(directive)) {
+			nodes = append(nodes, PreprocessorNode{NodeType: NodePreprocessor, Line: Line(lineNo), Directive: directive, Args: strings.TrimSpace(args)})
+		}
+		out.WriteByte('\n')
+	}
+	return out.String(), nodes, nil
+}
+
+// isStructuralDirective reports whether directive is one of the
+// conditional-nesting directives, which define the shape of the `#if`
+// chain rather than acting on the active branch's content: these are
+// preserved even when PreservePreprocessor sees them inside a branch that
+// isn't active, so a consumer can still reconstruct the full nesting.
+func isStructuralDirective(directive string) bool {
+	switch directive {
+	case "if", "ifdef", "ifndef", "elif", "else", "endif":
+		return true
+	}
+	return false
+}
+
+// splitDirective splits "define FOO 1" (the text after the `#`) into its
+// directive keyword and the raw text that follows it.
+func splitDirective(s string) (directive, args string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// splitDefine splits the argument of a `#define` into its macro name and
+// replacement text. Function-like macros (those with a `(` immediately
+// after the name) keep the `(` and the rest of their parameter list up to
+// the first space or tab as part of the name (any remainder of the list
+// starts the replacement text), so they are never matched by expandDefines
+// and are left as opaque
+// tokens for the lexer, per the v1 scope of this preprocessor.
+func splitDefine(args string) (macro, value string) {
+	i := strings.IndexAny(args, " \t")
+	if i < 0 {
+		return args, ""
+	}
+	return args[:i], strings.TrimSpace(args[i:])
+}
+
+// includePath extracts the path out of `"path"` or `<path>`.
+func includePath(args string) (string, bool) {
+	args = strings.TrimSpace(args)
+	if len(args) < 2 {
+		return "", false
+	}
+	if args[0] == '"' && strings.HasSuffix(args, `"`) {
+		return args[1 : len(args)-1], true
+	}
+	if args[0] == '<' && strings.HasSuffix(args, ">") {
+		return args[1 : len(args)-1], true
+	}
+	return "", false
+}
+
+var identRE = regexp.MustCompile(`[A-Za-z_][A-Za-z0-9_]*`)
+
+// expandDefines replaces whole-word occurrences of object-like macros with
+// their replacement text. Macro names holding a function-like signature
+// (see splitDefine) never match a bare identifier, so they pass through
+// untouched.
+func expandDefines(line string, defines map[string]string) string {
+	if len(defines) == 0 {
+		return line
+	}
+	return identRE.ReplaceAllStringFunc(line, func(ident string) string {
+		if value, ok := defines[ident]; ok {
+			return value
+		}
+		return ident
+	})
+}
+
+var exprTokenRE = regexp.MustCompile(`defined|[0-9]+|[A-Za-z_][A-Za-z0-9_]*|&&|\|\||==|!=|[()!<>]`)
+
+// evalCondition evaluates the tiny constant-expression grammar legal in a
+// `#if`/`#elif`: integer literals, `defined(X)`/`defined X`, `!`, `&&`,
+// `||`, `==`, `!=`, `<`, `>`, identifiers (their value when defined as an
+// integer, 1 when defined as anything else, 0 when undefined), and
+// parentheses.
+func evalCondition(expr string, defines map[string]string) (bool, error) {
+	tokens := exprTokenRE.FindAllString(expr, -1)
+	p := &condParser{tokens: tokens, defines: defines}
+	v, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("unexpected token %q in #if expression", p.tokens[p.pos])
+	}
+	return v != 0, nil
+}
+
+type condParser struct {
+	tokens  []string
+	pos     int
+	defines map[string]string
+}
+
+func (p *condParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *condParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *condParser) parseOr() (int, error) {
+	v, err := p.parseAnd()
+	if err != nil {
+		return 0, err
+	}
+	for p.peek() == "||" {
+		p.next()
+		rhs, err := p.parseAnd()
+		if err != nil {
+			return 0, err
+		}
+		v = boolToInt(v != 0 || rhs != 0)
+	}
+	return v, nil
+}
+
+func (p *condParser) parseAnd() (int, error) {
+	v, err := p.parseEquality()
+	if err != nil {
+		return 0, err
+	}
+	for p.peek() == "&&" {
+		p.next()
+		rhs, err := p.parseEquality()
+		if err != nil {
+			return 0, err
+		}
+		v = boolToInt(v != 0 && rhs != 0)
+	}
+	return v, nil
+}
+
+func (p *condParser) parseEquality() (int, error) {
+	v, err := p.parseRelational()
+	if err != nil {
+		return 0, err
+	}
+	for p.peek() == "==" || p.peek() == "!=" {
+		op := p.next()
+		rhs, err := p.parseRelational()
+		if err != nil {
+			return 0, err
+		}
+		if op == "==" {
+			v = boolToInt(v == rhs)
+		} else {
+			v = boolToInt(v != rhs)
+		}
+	}
+	return v, nil
+}
+
+func (p *condParser) parseRelational() (int, error) {
+	v, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for p.peek() == "<" || p.peek() == ">" {
+		op := p.next()
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		if op == "<" {
+			v = boolToInt(v < rhs)
+		} else {
+			v = boolToInt(v > rhs)
+		}
+	}
+	return v, nil
+}
+
+func (p *condParser) parseUnary() (int, error) {
+	if p.peek() == "!" {
+		p.next()
+		v, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		return boolToInt(v == 0), nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *condParser) parsePrimary() (int, error) {
+	tok := p.next()
+	switch {
+	case tok == "":
+		return 0, fmt.Errorf("unexpected end of #if expression")
+	case tok == "(":
+		v, err := p.parseOr()
+		if err != nil {
+			return 0, err
+		}
+		if p.next() != ")" {
+			return 0, fmt.Errorf("expected ')' in #if expression")
+		}
+		return v, nil
+	case tok == "defined":
+		paren := p.peek() == "("
+		if paren {
+			p.next()
+		}
+		name := p.next()
+		if paren {
+			if p.next() != ")" {
+				return 0, fmt.Errorf("expected ')' after defined(%s", name)
+			}
+		}
+		_, ok := p.defines[name]
+		return boolToInt(ok), nil
+	case isDigits(tok):
+		n, err := strconv.Atoi(tok)
+		if err != nil {
+			return 0, err
+		}
+		return n, nil
+	default:
+		if value, ok := p.defines[tok]; ok {
+			if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				return n, nil
+			}
+			return 1, nil
+		}
+		return 0, nil
+	}
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
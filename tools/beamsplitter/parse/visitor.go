@@ -0,0 +1,80 @@
+/*
+ * Copyright (C) 2022 The Android Open Source Project
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *      http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package parse
+
+// Visitor's Visit method is invoked by Walk for each node it encounters. If
+// the returned Visitor w is not nil and descend is true, Walk visits each
+// of node's children with w, followed by a call to w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor, descend bool)
+}
+
+// Walk traverses an AST in depth-first order, starting with root. For each
+// Node it calls v.Visit; if that returns a non-nil Visitor and descend is
+// true, Walk recurses into the node's children with the returned Visitor,
+// then calls that Visitor's Visit(nil) once all children are done.
+func Walk(root Node, v Visitor) {
+	if root == nil {
+		return
+	}
+	w, descend := v.Visit(root)
+	if w == nil || !descend {
+		return
+	}
+	for _, child := range children(root) {
+		Walk(child, w)
+	}
+	w.Visit(nil)
+}
+
+// children returns the immediate child Nodes of n: for a RootNode, its
+// namespace followed by its PreprocessorNodes; otherwise in source order.
+func children(n Node) []Node {
+	switch t := n.(type) {
+	case *RootNode:
+		var out []Node
+		if t.Child != nil {
+			out = append(out, t.Child)
+		}
+		for i := range t.Preprocessor {
+			out = append(out, &t.Preprocessor[i])
+		}
+		return out
+	case *NamespaceNode:
+		return t.Children
+	case *ClassNode:
+		return t.Members
+	case *StructNode:
+		return t.Members
+	default:
+		return nil
+	}
+}
+
+// inspector adapts a func(Node) bool to the Visitor interface, for Inspect.
+type inspector func(Node) bool
+
+func (f inspector) Visit(n Node) (Visitor, bool) {
+	return f, f(n)
+}
+
+// Inspect traverses an AST in depth-first order, calling f(n) for each Node
+// n (including nil, once for every call to Walk's descend). f returns
+// whether Inspect should continue into n's children.
+func Inspect(root Node, f func(Node) bool) {
+	Walk(root, inspector(f))
+}